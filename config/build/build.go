@@ -0,0 +1,302 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package build provides a fluent, validated API for constructing
+// types.Config values in Go, for programs (provisioners, Terraform-style
+// providers, test harnesses) that would otherwise hand-assemble
+// types.Config literals and risk drifting from the schema across minor
+// versions.
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/ignition/config/types"
+	"github.com/coreos/ignition/config/validate"
+	"github.com/coreos/ignition/config/validate/report"
+)
+
+var errInvalid = errors.New("config is not valid")
+
+// Config builds up a types.Config one resource at a time. Use NewConfig to
+// create one, chain Add* calls to populate it, and call Marshal to validate
+// and serialize the result. Each Add* call validates just the resource it
+// adds; any failures are recorded and surfaced as a fatal report the next
+// time Marshal is called, so a caller can tell which call introduced the
+// problem instead of learning about it only once, at the end, for the whole
+// config.
+type Config struct {
+	cfg  types.Config
+	errs []error
+}
+
+// NewConfig returns an empty Config pinned to the latest known
+// ignition.version.
+func NewConfig() *Config {
+	cfg := types.Config{}
+	cfg.Ignition.Version = types.MaxVersion
+	return &Config{cfg: cfg}
+}
+
+// File describes a regular file to add with AddFile.
+type File struct {
+	Path      string
+	Mode      int
+	Contents  Source
+	Overwrite bool
+}
+
+// Source is the contents of a file, built with Inline or Remote. Gzip only
+// takes effect on an Inline source: it compresses the inline data itself
+// before it's embedded, so the "compression" tag it sets on the resulting
+// file always matches the bytes Ignition will actually fetch. Calling it on
+// a Remote source just tags the (already-remote) content as gzipped; it
+// does not and cannot compress bytes this package never sees.
+type Source struct {
+	inline      []byte
+	isInline    bool
+	url         string
+	compression string
+}
+
+// Inline returns a Source that embeds data directly in the config as a
+// data: URL.
+func Inline(data string) Source {
+	return Source{inline: []byte(data), isInline: true}
+}
+
+// Remote returns a Source that points at an external URL, fetched by
+// Ignition at provisioning time.
+func Remote(url string) Source {
+	return Source{url: url}
+}
+
+// Gzip marks s as gzip-compressed. For an Inline source, the inline data is
+// actually gzip-compressed before being embedded; for a Remote source, it
+// only sets the tag Ignition uses to decompress what it fetches, since the
+// remote bytes are expected to already be gzipped.
+func (s Source) Gzip() Source {
+	s.compression = "gzip"
+	return s
+}
+
+// resolve turns s into the FileContents Ignition actually reads: compressing
+// and base64-encoding inline data marked Gzip, percent-encoding inline data
+// that isn't, and passing remote URLs through untouched.
+func (s Source) resolve() (types.FileContents, error) {
+	if !s.isInline {
+		return types.FileContents{Source: s.url, Compression: s.compression}, nil
+	}
+
+	if s.compression != "gzip" {
+		return types.FileContents{Source: "data:," + urlEscape(string(s.inline))}, nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(s.inline); err != nil {
+		return types.FileContents{}, fmt.Errorf("gzipping inline contents: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return types.FileContents{}, fmt.Errorf("gzipping inline contents: %v", err)
+	}
+
+	return types.FileContents{
+		Source:      "data:;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Compression: "gzip",
+	}, nil
+}
+
+// AddFile appends f to the config's storage.files list.
+func (c *Config) AddFile(f File) *Config {
+	contents, err := f.Contents.resolve()
+	if err != nil {
+		c.errs = append(c.errs, fmt.Errorf("AddFile(%q): %v", f.Path, err))
+		return c
+	}
+
+	file := types.File{
+		Node: types.Node{
+			Path:      f.Path,
+			Overwrite: &f.Overwrite,
+		},
+		FileEmbedded1: types.FileEmbedded1{
+			Mode:     f.Mode,
+			Contents: contents,
+		},
+	}
+
+	c.validate(types.Config{Storage: types.Storage{Files: []types.File{file}}}, fmt.Sprintf("AddFile(%q)", f.Path))
+	c.cfg.Storage.Files = append(c.cfg.Storage.Files, file)
+	return c
+}
+
+// Disk describes a disk layout to add with AddDisk.
+type Disk struct {
+	Device     string
+	WipeTable  bool
+	Partitions []types.Partition
+}
+
+// AddDisk appends d to the config's storage.disks list.
+func (c *Config) AddDisk(d Disk) *Config {
+	disk := types.Disk{
+		Device:     d.Device,
+		WipeTable:  d.WipeTable,
+		Partitions: d.Partitions,
+	}
+
+	c.validate(types.Config{Storage: types.Storage{Disks: []types.Disk{disk}}}, fmt.Sprintf("AddDisk(%q)", d.Device))
+	c.cfg.Storage.Disks = append(c.cfg.Storage.Disks, disk)
+	return c
+}
+
+// Raid describes a software RAID array to add with AddRaid.
+type Raid struct {
+	Name    string
+	Level   string
+	Devices []string
+	Spares  int
+}
+
+// AddRaid appends r to the config's storage.raid list.
+func (c *Config) AddRaid(r Raid) *Config {
+	devices := make([]types.Device, len(r.Devices))
+	for i, d := range r.Devices {
+		devices[i] = types.Device(d)
+	}
+
+	raid := types.Raid{
+		Name:    r.Name,
+		Level:   r.Level,
+		Devices: devices,
+		Spares:  r.Spares,
+	}
+
+	c.validate(types.Config{Storage: types.Storage{Raid: []types.Raid{raid}}}, fmt.Sprintf("AddRaid(%q)", r.Name))
+	c.cfg.Storage.Raid = append(c.cfg.Storage.Raid, raid)
+	return c
+}
+
+// Filesystem describes a filesystem to add with AddFilesystem.
+type Filesystem struct {
+	Name  string
+	Mount *types.Mount
+	Path  *string
+}
+
+// AddFilesystem appends fs to the config's storage.filesystems list.
+func (c *Config) AddFilesystem(fs Filesystem) *Config {
+	filesystem := types.Filesystem{
+		Name:  fs.Name,
+		Mount: fs.Mount,
+		Path:  fs.Path,
+	}
+
+	c.validate(types.Config{Storage: types.Storage{Filesystems: []types.Filesystem{filesystem}}}, fmt.Sprintf("AddFilesystem(%q)", fs.Name))
+	c.cfg.Storage.Filesystems = append(c.cfg.Storage.Filesystems, filesystem)
+	return c
+}
+
+// AddSystemdUnit appends u to the config's systemd.units list.
+func (c *Config) AddSystemdUnit(u types.SystemdUnit) *Config {
+	c.validate(types.Config{Systemd: types.Systemd{Units: []types.SystemdUnit{u}}}, fmt.Sprintf("AddSystemdUnit(%q)", u.Name))
+	c.cfg.Systemd.Units = append(c.cfg.Systemd.Units, u)
+	return c
+}
+
+// AddNetworkdUnit appends u to the config's networkd.units list.
+func (c *Config) AddNetworkdUnit(u types.NetworkdUnit) *Config {
+	c.validate(types.Config{Networkd: types.Networkd{Units: []types.NetworkdUnit{u}}}, fmt.Sprintf("AddNetworkdUnit(%q)", u.Name))
+	c.cfg.Networkd.Units = append(c.cfg.Networkd.Units, u)
+	return c
+}
+
+// AddUser appends u to the config's passwd.users list.
+func (c *Config) AddUser(u types.User) *Config {
+	c.validate(types.Config{Passwd: types.Passwd{Users: []types.User{u}}}, fmt.Sprintf("AddUser(%q)", u.Name))
+	c.cfg.Passwd.Users = append(c.cfg.Passwd.Users, u)
+	return c
+}
+
+// AddGroup appends g to the config's passwd.groups list.
+func (c *Config) AddGroup(g types.Group) *Config {
+	c.validate(types.Config{Passwd: types.Passwd{Groups: []types.Group{g}}}, fmt.Sprintf("AddGroup(%q)", g.Name))
+	c.cfg.Passwd.Groups = append(c.cfg.Passwd.Groups, g)
+	return c
+}
+
+// Config returns the types.Config built so far, without validating it.
+func (c *Config) Config() types.Config {
+	return c.cfg
+}
+
+// validate runs the same validators validate.Validate uses over partial, a
+// throwaway config containing only the resource an Add* call just built,
+// and records any error it finds against context (typically naming the
+// Add* call and resource) so Marshal can report exactly which call
+// introduced a problem.
+func (c *Config) validate(partial types.Config, context string) {
+	r := validate.ValidateWithoutSource(partial)
+	for _, e := range r.Entries {
+		if e.Kind == report.EntryError {
+			c.errs = append(c.errs, fmt.Errorf("%s: %s", context, e.Message))
+		}
+	}
+}
+
+// Marshal validates the config with the same validators validate.Validate
+// uses, and if it is valid, serializes it to canonical Ignition JSON. Any
+// failures recorded by earlier Add* calls, plus anything only visible once
+// the whole config is assembled, are returned together as a fatal report
+// alongside an error; a non-fatal report (warnings, deprecations) is
+// returned alongside the marshaled bytes on success.
+func (c *Config) Marshal() ([]byte, report.Report, error) {
+	var r report.Report
+	for _, err := range c.errs {
+		r.Add(report.Entry{Kind: report.EntryError, Message: err.Error()})
+	}
+
+	r.Merge(validate.ValidateWithoutSource(c.cfg))
+	if r.IsFatal() {
+		return nil, r, errInvalid
+	}
+
+	data, err := json.Marshal(c.cfg)
+	if err != nil {
+		return nil, r, err
+	}
+
+	return data, r, nil
+}
+
+func urlEscape(data string) string {
+	escaped := make([]byte, 0, len(data))
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9', b == '-' || b == '_' || b == '.' || b == '~':
+			escaped = append(escaped, b)
+		default:
+			escaped = append(escaped, '%', hex[b>>4], hex[b&0xf])
+		}
+	}
+	return string(escaped)
+}
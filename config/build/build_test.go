@@ -0,0 +1,81 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestInlineGzipCompressesContent checks that an Inline source marked Gzip
+// actually carries gzip-compressed bytes, not the plain percent-encoded
+// text tagged with a compression claim Ignition can't back up.
+func TestInlineGzipCompressesContent(t *testing.T) {
+	contents, err := Inline("host1").Gzip().resolve()
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if contents.Compression != "gzip" {
+		t.Fatalf("expected compression %q, got %q", "gzip", contents.Compression)
+	}
+
+	const prefix = "data:;base64,"
+	if !strings.HasPrefix(contents.Source, prefix) {
+		t.Fatalf("expected a base64 data URL, got %q", contents.Source)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(contents.Source, prefix))
+	if err != nil {
+		t.Fatalf("decoding base64: %v", err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("source is not valid gzip data: %v", err)
+	}
+	got, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("reading gzip stream: %v", err)
+	}
+	if string(got) != "host1" {
+		t.Fatalf("got %q, want %q", got, "host1")
+	}
+}
+
+// TestAddFileRecordsValidationError checks that an invalid resource added
+// through AddFile is recorded immediately, so the eventual Marshal failure
+// can be traced back to the call that introduced it.
+func TestAddFileRecordsValidationError(t *testing.T) {
+	c := NewConfig().AddFile(File{Path: "", Contents: Inline("x")})
+
+	_, r, err := c.Marshal()
+	if err == nil || !r.IsFatal() {
+		t.Fatalf("expected a fatal error for a file with no path, got err=%v report=%v", err, r)
+	}
+
+	traced := false
+	for _, e := range r.Entries {
+		if strings.HasPrefix(e.Message, `AddFile(""): `) {
+			traced = true
+		}
+	}
+	if !traced {
+		t.Fatalf("expected a report entry naming the AddFile call, got %v", r)
+	}
+}
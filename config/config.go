@@ -17,6 +17,7 @@ package config
 import (
 	"bytes"
 	"errors"
+	"fmt"
 
 	"github.com/coreos/ignition/config/types"
 	"github.com/coreos/ignition/config/v1"
@@ -133,6 +134,78 @@ func ParseFromV1(rawConfig []byte) (types.Config, error) {
 	return TranslateFromV1(config)
 }
 
+// minorTranslator rewrites a raw config pinned to one minor version of the
+// current major version so it can be handed to ParseFromLatest. Register one
+// here only when a minor needs more than the identity translation below,
+// e.g. a field was renamed or removed outright between that minor and the
+// latest schema.
+type minorTranslator func(rawConfig []byte) ([]byte, error)
+
+// minorTranslators maps a minor version to the translator that rewrites it
+// forward to the latest schema. Minors with no entry fall back to
+// retargetToLatest, which is correct as long as the only difference between
+// that minor and the latest is fields the latest schema added.
+var minorTranslators = map[int64]minorTranslator{}
+
+// ParseCompatibleVersion parses rawConfig the same way ParseFromLatest does,
+// but also accepts configs whose ignition.version declares an older minor of
+// the current major version. Configs of any other major version (including
+// the v1 configs Parse accepts) are rejected with a fatal report, as are
+// configs with no ignition.version. The result is always translated up to the latest
+// known schema, with fields added by later minors set to their zero values,
+// so callers never need to fan out on minor version themselves.
+//
+// If rawConfig declares a minor version newer than this package knows about,
+// or a minor translator reports it cannot bring the config forward (for
+// example because a field it relied on was removed), ParseCompatibleVersion
+// returns a fatal report identifying the minor version and why.
+func ParseCompatibleVersion(rawConfig []byte) (types.Config, report.Report, error) {
+	v := version(rawConfig)
+	if v.Major != types.MaxVersion.Major || v.Minor > types.MaxVersion.Minor {
+		err := fmt.Errorf("ignition version %d.%d is not forward-compatible with %d.%d", v.Major, v.Minor, types.MaxVersion.Major, types.MaxVersion.Minor)
+		return types.Config{}, report.ReportFromError(err, report.EntryError), err
+	}
+
+	if v.Minor == types.MaxVersion.Minor {
+		return ParseFromLatest(rawConfig)
+	}
+
+	translate, ok := minorTranslators[v.Minor]
+	if !ok {
+		translate = retargetToLatest
+	}
+
+	translated, err := translate(rawConfig)
+	if err != nil {
+		wrapped := fmt.Errorf("translating version %d.%d config forward to %d.%d: %v", v.Major, v.Minor, types.MaxVersion.Major, types.MaxVersion.Minor, err)
+		return types.Config{}, report.ReportFromError(wrapped, report.EntryError), wrapped
+	}
+
+	return ParseFromLatest(translated)
+}
+
+// retargetToLatest rewrites rawConfig's ignition.version to the latest known
+// minor without touching anything else. This is a correct, zero-effect
+// translation as long as every field the older minor understands still
+// means the same thing in the latest schema; ParseFromLatest then fills in
+// any fields the older minor didn't have with their zero values via normal
+// JSON unmarshaling.
+func retargetToLatest(rawConfig []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rawConfig, &doc); err != nil {
+		return nil, err
+	}
+
+	ignition, _ := doc["ignition"].(map[string]interface{})
+	if ignition == nil {
+		ignition = map[string]interface{}{}
+		doc["ignition"] = ignition
+	}
+	ignition["version"] = types.MaxVersion
+
+	return json.Marshal(doc)
+}
+
 func version(rawConfig []byte) types.IgnitionVersion {
 	var composite struct {
 		Version  *int `json:"ignitionVersion"`
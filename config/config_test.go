@@ -0,0 +1,57 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/ignition/config/types"
+)
+
+// TestParseCompatibleVersionTranslatesOlderMinor checks that a config
+// pinned to an older minor of the current major version round-trips
+// through ParseCompatibleVersion instead of being rejected outright.
+func TestParseCompatibleVersionTranslatesOlderMinor(t *testing.T) {
+	older := types.MaxVersion
+	older.Minor--
+	if older.Minor < 0 {
+		t.Skip("current major has only one known minor")
+	}
+
+	raw := []byte(fmt.Sprintf(`{"ignition":{"version":{"major":%d,"minor":%d}}}`, older.Major, older.Minor))
+
+	cfg, r, err := ParseCompatibleVersion(raw)
+	if err != nil {
+		t.Fatalf("ParseCompatibleVersion returned error: %v (report: %v)", err, r)
+	}
+	if cfg.Ignition.Version != types.MaxVersion {
+		t.Errorf("expected config to be translated to %+v, got %+v", types.MaxVersion, cfg.Ignition.Version)
+	}
+}
+
+// TestParseCompatibleVersionRejectsNewerMinor checks that a config
+// declaring a minor version newer than this package knows about is
+// rejected with a fatal report rather than silently accepted.
+func TestParseCompatibleVersionRejectsNewerMinor(t *testing.T) {
+	newer := types.MaxVersion
+	newer.Minor++
+
+	raw := []byte(fmt.Sprintf(`{"ignition":{"version":{"major":%d,"minor":%d}}}`, newer.Major, newer.Minor))
+
+	if _, r, err := ParseCompatibleVersion(raw); err == nil || !r.IsFatal() {
+		t.Fatalf("expected a fatal error for an unknown newer minor, got err=%v report=%v", err, r)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/ignition/config/types"
+	"github.com/coreos/ignition/config/validate/report"
+)
+
+// Fetcher fetches the raw bytes of a config referenced by a data:, http(s)://
+// or oem:// URL. It is the caller's responsibility to provide an
+// implementation that knows how to reach oem:// sources for the platform
+// Ignition is validating configs for.
+type Fetcher interface {
+	Fetch(source string, headers http.Header) ([]byte, error)
+}
+
+// ParseOptions controls the optional, more expensive behaviors of
+// ParseWithOptions.
+type ParseOptions struct {
+	// FollowReferences causes ParseWithOptions to fetch and recursively
+	// parse every config referenced by config.ignition.config.merge and
+	// config.ignition.config.replace, folding their reports into the
+	// parent's.
+	FollowReferences bool
+	// Fetcher resolves the sources of merge/replace references. Required
+	// when FollowReferences is true. HTTPFetcher{} handles http(s):// and
+	// data: out of the box; wrap or replace it to add oem:// support.
+	Fetcher Fetcher
+}
+
+// HTTPFetcher is a Fetcher that understands data: and http(s):// URLs. It
+// has no knowledge of oem:// sources, since those are platform-specific;
+// embed it in a Fetcher that adds oem:// support if needed.
+type HTTPFetcher struct {
+	Client *http.Client
+	// CABundle, if set, is added to the system trust store (not used in
+	// place of it) when fetching https:// sources, without disturbing any
+	// other Transport or Client settings.
+	CABundle []byte
+}
+
+func (f HTTPFetcher) Fetch(source string, headers http.Header) ([]byte, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "data":
+		return fetchDataURL(u)
+	case "http", "https":
+		return f.fetchHTTP(u, headers)
+	case "oem":
+		return nil, fmt.Errorf("oem:// sources require a platform-specific Fetcher")
+	default:
+		return nil, fmt.Errorf("unsupported config reference scheme %q", u.Scheme)
+	}
+}
+
+func fetchDataURL(u *url.URL) ([]byte, error) {
+	comma := strings.Index(u.Opaque, ",")
+	if comma < 0 {
+		return nil, fmt.Errorf("malformed data URL %q", u.String())
+	}
+
+	meta, data := u.Opaque[:comma], u.Opaque[comma+1:]
+	if strings.HasSuffix(meta, ";base64") {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	decoded, err := url.QueryUnescape(data)
+	return []byte(decoded), err
+}
+
+func (f HTTPFetcher) fetchHTTP(u *url.URL, headers http.Header) ([]byte, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if len(f.CABundle) > 0 {
+		withCABundle, err := addCABundle(client, f.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		client = withCABundle
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fetching %q: unexpected status %s", u.String(), resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// addCABundle returns a shallow copy of client whose transport trusts the
+// system roots plus caBundle, leaving everything else about client (its
+// Transport's other settings, Timeout, CheckRedirect, ...) intact. It never
+// mutates client or its Transport in place.
+func addCABundle(client *http.Client, caBundle []byte) (*http.Client, error) {
+	var transport *http.Transport
+	if t, ok := client.Transport.(*http.Transport); ok && t != nil {
+		transport = t.Clone()
+	} else if client.Transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	} else {
+		return nil, fmt.Errorf("CABundle requires an *http.Transport (or nil Transport), got %T", client.Transport)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("CABundle contains no valid PEM-encoded certificates")
+	}
+
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	} else {
+		transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+	}
+	transport.TLSClientConfig.RootCAs = pool
+
+	clientCopy := *client
+	clientCopy.Transport = transport
+	return &clientCopy, nil
+}
+
+// ParseWithOptions parses rawConfig like ParseFromLatest, and when
+// options.FollowReferences is set, additionally fetches and recursively
+// parses every config referenced by config.ignition.config.merge and
+// config.ignition.config.replace. Each child's report is merged into the
+// returned report with a path prefix (e.g. "$.ignition.config.merge[0]")
+// identifying which reference it came from, so schema errors in merged or
+// replaced fragments surface at author time instead of at boot.
+func ParseWithOptions(rawConfig []byte, options ParseOptions) (types.Config, report.Report, error) {
+	cfg, r, err := ParseFromLatest(rawConfig)
+	if err != nil || !options.FollowReferences {
+		return cfg, r, err
+	}
+
+	if options.Fetcher == nil {
+		err := fmt.Errorf("ParseOptions.FollowReferences requires a non-nil Fetcher")
+		r.Add(report.Entry{Kind: report.EntryError, Message: err.Error()})
+		return types.Config{}, r, err
+	}
+
+	followReferences(cfg, "$.ignition.config", options.Fetcher, map[string]bool{}, &r)
+
+	if r.IsFatal() {
+		return types.Config{}, r, ErrInvalid
+	}
+
+	return cfg, r, nil
+}
+
+// followReferences walks cfg's merge and replace references, fetching and
+// recursively parsing each one and merging its report into r under path,
+// the json-path-ish location of the reference that produced it. ancestors
+// holds the sources on the path from the root config down to cfg; each
+// branch gets its own copy so that two independent references to the same
+// common base (a "diamond" dependency) are not mistaken for a cycle.
+func followReferences(cfg types.Config, path string, fetcher Fetcher, ancestors map[string]bool, r *report.Report) {
+	for i, ref := range cfg.Ignition.Config.Merge {
+		followReference(ref, fmt.Sprintf("%s.merge[%d]", path, i), fetcher, cloneVisited(ancestors), r)
+	}
+	if cfg.Ignition.Config.Replace != nil {
+		followReference(*cfg.Ignition.Config.Replace, path+".replace", fetcher, cloneVisited(ancestors), r)
+	}
+}
+
+// cloneVisited copies an ancestor set so sibling branches don't share
+// mutations made while descending into one another.
+func cloneVisited(ancestors map[string]bool) map[string]bool {
+	clone := make(map[string]bool, len(ancestors)+1)
+	for k := range ancestors {
+		clone[k] = true
+	}
+	return clone
+}
+
+func followReference(ref types.ConfigReference, path string, fetcher Fetcher, ancestors map[string]bool, r *report.Report) {
+	if ref.Source == "" {
+		return
+	}
+	if ancestors[ref.Source] {
+		r.Add(report.Entry{
+			Kind:    report.EntryError,
+			Message: fmt.Sprintf("%s: cycle detected while following %q", path, ref.Source),
+		})
+		return
+	}
+	ancestors[ref.Source] = true
+
+	headers := http.Header{}
+	for _, h := range ref.HTTPHeaders {
+		headers.Add(h.Name, h.Value)
+	}
+
+	raw, err := fetcher.Fetch(ref.Source, headers)
+	if err != nil {
+		r.Add(report.Entry{
+			Kind:    report.EntryError,
+			Message: fmt.Sprintf("%s: failed to fetch %q: %v", path, ref.Source, err),
+		})
+		return
+	}
+
+	child, cr, err := ParseFromLatest(raw)
+	for i := range cr.Entries {
+		cr.Entries[i].Message = fmt.Sprintf("%s: %s", path, cr.Entries[i].Message)
+	}
+	r.Merge(cr)
+	if err != nil {
+		return
+	}
+
+	followReferences(child, path, fetcher, ancestors, r)
+}
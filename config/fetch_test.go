@@ -0,0 +1,137 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/coreos/ignition/config/types"
+	"github.com/coreos/ignition/config/validate/report"
+)
+
+// TestParseWithOptionsNilFetcher checks that FollowReferences with a nil
+// Fetcher returns a fatal report instead of panicking on the nil interface
+// the first time a reference would be fetched.
+func TestParseWithOptionsNilFetcher(t *testing.T) {
+	raw := []byte(fmt.Sprintf(
+		`{"ignition":{"version":{"major":%d,"minor":%d},"config":{"merge":[{"source":"data:,{}"}]}}}`,
+		types.MaxVersion.Major, types.MaxVersion.Minor,
+	))
+
+	_, r, err := ParseWithOptions(raw, ParseOptions{FollowReferences: true})
+	if err == nil || !r.IsFatal() {
+		t.Fatalf("expected a fatal error for a nil Fetcher, got err=%v report=%v", err, r)
+	}
+}
+
+// TestAddCABundlePreservesClientSettings checks that adding a CABundle
+// keeps the caller's other Client and Transport settings (here, a non-zero
+// Timeout) instead of being thrown away for a bare &http.Client{}.
+func TestAddCABundlePreservesClientSettings(t *testing.T) {
+	original := &http.Client{Timeout: 42 * time.Second}
+
+	withBundle, err := addCABundle(original, []byte(testCACert))
+	if err != nil {
+		t.Fatalf("addCABundle: %v", err)
+	}
+
+	if withBundle.Timeout != original.Timeout {
+		t.Errorf("expected Timeout %v to be preserved, got %v", original.Timeout, withBundle.Timeout)
+	}
+	if original.Transport != nil {
+		t.Errorf("addCABundle should not mutate the original client's Transport")
+	}
+}
+
+// TestAddCABundleKeepsSystemRoots checks that adding a CABundle augments the
+// system trust store -- a pool seeded from x509.SystemCertPool(), which on
+// most systems starts non-empty -- rather than replacing it with a pool
+// that contains only the bundle's own certificate.
+func TestAddCABundleKeepsSystemRoots(t *testing.T) {
+	systemPool, err := x509.SystemCertPool()
+	if err != nil || systemPool == nil || len(systemPool.Subjects()) == 0 {
+		t.Skip("no non-empty system cert pool available in this environment")
+	}
+
+	withBundle, err := addCABundle(&http.Client{}, []byte(testCACert))
+	if err != nil {
+		t.Fatalf("addCABundle: %v", err)
+	}
+
+	transport, ok := withBundle.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil {
+		t.Fatalf("expected a Transport with a non-nil RootCAs pool, got %+v", withBundle.Transport)
+	}
+
+	if len(transport.TLSClientConfig.RootCAs.Subjects()) <= len(systemPool.Subjects()) {
+		t.Fatalf("expected the bundle's cert to be added on top of the system pool's %d certs", len(systemPool.Subjects()))
+	}
+}
+
+// testCACert is a throwaway self-signed certificate, only used to exercise
+// AppendCertsFromPEM; its subject is never actually validated against
+// anything.
+const testCACert = `-----BEGIN CERTIFICATE-----
+MIIBVzCB/aADAgECAgEBMAoGCCqGSM49BAMCMBIxEDAOBgNVBAoTB0FjbWUgQ28w
+IBcNNzAwMTAxMDAwMDAwWhgPMjA3MDAxMDEwMDAwMDBaMBIxEDAOBgNVBAoTB0Fj
+bWUgQ28wWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAAQwXQ9grWxN3z8hYr51xbu+
+AmBQOOOHFF52LvxI/2RvjNlymX/jwPoaESxomXUsh7/bODgov3fsrgDj7yfjHzZw
+o0IwQDAOBgNVHQ8BAf8EBAMCAoQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQU
+F1JS1du/7+QhjXzhdq9GZ1dGJ0QwCgYIKoZIzj0EAwIDSQAwRgIhAKaoV8zDpIWp
+/mnkYaIU9SzmaXpxGOFirPUdf5z2x0eZAiEA0n5IEnKIoP+IONJg1x6B6j420PQE
+uJBvrIzKNuwWcck=
+-----END CERTIFICATE-----`
+
+// stubFetcher serves fixed content for a set of sources and fails any
+// source not in the map, so tests can model arbitrary merge/replace graphs.
+type stubFetcher map[string][]byte
+
+var errUnknownSource = errors.New("unknown source")
+
+func (f stubFetcher) Fetch(source string, _ http.Header) ([]byte, error) {
+	if raw, ok := f[source]; ok {
+		return raw, nil
+	}
+	return nil, errUnknownSource
+}
+
+// TestFollowReferencesDiamondIsNotACycle checks that two independent,
+// non-cyclic references to the same common base config (a "diamond"
+// dependency) are both followed, rather than the second one being rejected
+// as a false-positive cycle.
+func TestFollowReferencesDiamondIsNotACycle(t *testing.T) {
+	base := []byte(fmt.Sprintf(`{"ignition":{"version":{"major":%d,"minor":%d}}}`, types.MaxVersion.Major, types.MaxVersion.Minor))
+	fetcher := stubFetcher{"data:,base": base}
+
+	cfg := types.Config{}
+	cfg.Ignition.Config.Merge = []types.ConfigReference{
+		{Source: "data:,base"},
+		{Source: "data:,base"},
+	}
+
+	var r report.Report
+	followReferences(cfg, "$.ignition.config", fetcher, map[string]bool{}, &r)
+
+	for _, e := range r.Entries {
+		if e.Kind == report.EntryError {
+			t.Fatalf("unexpected error following a diamond dependency: %s", e.Message)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/ignition/config/types"
+	"github.com/coreos/ignition/config/validate/report"
+)
+
+// downgrader strips or rejects the fields a given minor version of the
+// current major doesn't know about, turning a latest-schema types.Config
+// into one that's safe to marshal as that older minor. It returns a fatal
+// report if cfg uses a field the target version cannot represent.
+type downgrader func(cfg types.Config) (types.Config, report.Report)
+
+// downgraders maps a minor version to the downgrader that produces it from
+// the latest schema. The latest minor itself never needs an entry, since
+// MarshalForVersion marshals cfg as-is in that case. Every other minor MUST
+// have an entry: MarshalForVersion refuses to marshal for a minor it has no
+// registered downgrader for, rather than risk silently emitting fields that
+// minor doesn't understand.
+var downgraders = map[int64]downgrader{
+	0: downgradeRaidSpares,
+}
+
+// downgradeRaidSpares rejects configs that use storage.raid[].spares, a
+// field added after minor 0, and otherwise passes cfg through unchanged
+// since nothing else differs between minor 0 and the latest schema.
+func downgradeRaidSpares(cfg types.Config) (types.Config, report.Report) {
+	var r report.Report
+	for i, raid := range cfg.Storage.Raid {
+		if raid.Spares != 0 {
+			r.Add(report.Entry{
+				Kind:    report.EntryError,
+				Message: fmt.Sprintf("storage.raid[%d] (%q) sets \"spares\", which is not supported by this version", i, raid.Name),
+			})
+		}
+	}
+
+	return cfg, r
+}
+
+// MarshalForVersion serializes cfg, a types.Config in the latest known
+// schema, as JSON conforming to the requested older minor version of the
+// same major line. If cfg uses fields the target version doesn't support
+// (for example RAID options added in a later minor), it returns a fatal
+// report describing which field and why, instead of silently dropping data.
+func MarshalForVersion(cfg types.Config, v types.IgnitionVersion) ([]byte, report.Report, error) {
+	if v.Major != types.MaxVersion.Major {
+		err := fmt.Errorf("unsupported major version %d.%d (this package only supports %d.x)", v.Major, v.Minor, types.MaxVersion.Major)
+		return nil, report.ReportFromError(err, report.EntryError), err
+	}
+
+	if v.Minor > types.MaxVersion.Minor {
+		err := fmt.Errorf("minor version %d.%d is newer than the latest known version %d.%d", v.Major, v.Minor, types.MaxVersion.Major, types.MaxVersion.Minor)
+		return nil, report.ReportFromError(err, report.EntryError), err
+	}
+
+	out := cfg
+	var r report.Report
+	if v.Minor != types.MaxVersion.Minor {
+		down, ok := downgraders[v.Minor]
+		if !ok {
+			err := fmt.Errorf("no downgrader registered for version %d.%d; refusing to marshal a config that may use fields it doesn't support", v.Major, v.Minor)
+			return nil, report.ReportFromError(err, report.EntryError), err
+		}
+
+		var dr report.Report
+		out, dr = down(cfg)
+		r.Merge(dr)
+		if r.IsFatal() {
+			return nil, r, ErrInvalid
+		}
+	}
+
+	out.Ignition.Version = v
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, r, err
+	}
+
+	return data, r, nil
+}
+
+// acceptParam is the MIME parameter NegotiateVersion looks for in an
+// Accept: application/vnd.coreos.ignition+json; version=X.Y header.
+const acceptParam = "version="
+
+// ignitionMediaType is the only media type NegotiateVersion will read a
+// version= parameter from; a version= parameter on any other media range
+// (e.g. "text/html; version=9.9") is irrelevant to Ignition and ignored.
+const ignitionMediaType = "application/vnd.coreos.ignition+json"
+
+// NegotiateVersion parses a MIME Accept header of the form
+// "application/vnd.coreos.ignition+json; version=X.Y" (optionally one of
+// several comma-separated media ranges) and returns the highest version of
+// the current major line that both the client and this package support. If
+// none of the requested versions are supported, or none of the media ranges
+// naming application/vnd.coreos.ignition+json has a version parameter, it
+// returns an error.
+func NegotiateVersion(acceptHeader string) (types.IgnitionVersion, error) {
+	best := types.IgnitionVersion{}
+	found := false
+
+	for _, mediaRange := range strings.Split(acceptHeader, ",") {
+		params := strings.Split(mediaRange, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), ignitionMediaType) {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, acceptParam) {
+				continue
+			}
+
+			v, err := parseVersion(strings.TrimPrefix(param, acceptParam))
+			if err != nil {
+				continue
+			}
+			if v.Major != types.MaxVersion.Major || v.Minor > types.MaxVersion.Minor {
+				continue
+			}
+			if !found || v.Minor > best.Minor {
+				best = v
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return types.IgnitionVersion{}, fmt.Errorf("no supported %s version found in Accept header %q", ignitionMediaType, acceptHeader)
+	}
+
+	return best, nil
+}
+
+func parseVersion(s string) (types.IgnitionVersion, error) {
+	parts := strings.SplitN(s, ".", 2)
+	major, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return types.IgnitionVersion{}, err
+	}
+
+	var minor int64
+	if len(parts) == 2 {
+		if minor, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return types.IgnitionVersion{}, err
+		}
+	}
+
+	return types.IgnitionVersion{Major: major, Minor: minor}, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/ignition/config/types"
+)
+
+// TestMarshalForVersionRejectsUnsupportedField checks that a config using a
+// field the target minor doesn't understand is rejected with a fatal
+// report, rather than silently marshaled with the unsupported field intact.
+func TestMarshalForVersionRejectsUnsupportedField(t *testing.T) {
+	cfg := types.Config{
+		Storage: types.Storage{
+			Raid: []types.Raid{{Name: "data", Level: "1", Spares: 1}},
+		},
+	}
+
+	if _, r, err := MarshalForVersion(cfg, types.IgnitionVersion{Major: types.MaxVersion.Major, Minor: 0}); err == nil || !r.IsFatal() {
+		t.Fatalf("expected a fatal error for an unsupported raid.spares, got err=%v report=%v", err, r)
+	}
+}
+
+// TestMarshalForVersionRejectsUnregisteredMinor checks that a minor with no
+// registered downgrader is refused outright, instead of being marshaled
+// as-is with fields it may not support.
+func TestMarshalForVersionRejectsUnregisteredMinor(t *testing.T) {
+	target := int64(-1)
+	for m := int64(0); m < types.MaxVersion.Minor; m++ {
+		if _, ok := downgraders[m]; !ok {
+			target = m
+			break
+		}
+	}
+	if target < 0 {
+		t.Skip("every older minor already has a registered downgrader")
+	}
+
+	cfg := types.Config{}
+	if _, r, err := MarshalForVersion(cfg, types.IgnitionVersion{Major: types.MaxVersion.Major, Minor: target}); err == nil || !r.IsFatal() {
+		t.Fatalf("expected a fatal error for an unregistered minor, got err=%v report=%v", err, r)
+	}
+}
+
+// TestNegotiateVersionIgnoresOtherMediaTypes checks that a version=
+// parameter attached to a media range other than
+// application/vnd.coreos.ignition+json is never treated as a candidate
+// version, even when it's otherwise well-formed and in range.
+func TestNegotiateVersionIgnoresOtherMediaTypes(t *testing.T) {
+	if types.MaxVersion.Minor == 0 {
+		t.Skip("need an older minor distinct from the latest to construct this case")
+	}
+
+	header := fmt.Sprintf("text/html; version=%d.0, application/vnd.coreos.ignition+json", types.MaxVersion.Major)
+	if _, err := NegotiateVersion(header); err == nil {
+		t.Fatalf("expected an error: no version was associated with %s", ignitionMediaType)
+	}
+}
+
+// TestNegotiateVersionPicksIgnitionVersion checks that a version attached
+// to application/vnd.coreos.ignition+json is still correctly picked
+// amongst other, unrelated media ranges.
+func TestNegotiateVersionPicksIgnitionVersion(t *testing.T) {
+	header := fmt.Sprintf("text/html; version=0.0, application/vnd.coreos.ignition+json; version=%d.%d",
+		types.MaxVersion.Major, types.MaxVersion.Minor)
+
+	v, err := NegotiateVersion(header)
+	if err != nil {
+		t.Fatalf("NegotiateVersion: %v", err)
+	}
+	if v != types.MaxVersion {
+		t.Fatalf("expected %+v, got %+v", types.MaxVersion, v)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package yaml provides a human-authored, YAML-based frontend for Ignition
+// configs. It accepts the same schema as types.Config with a handful of
+// ergonomic additions (inline file contents from local paths, automatic
+// base64/gzip encoding, and string-form storage sizes) and transpiles it to
+// canonical Ignition JSON.
+package yaml
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/ignition/config"
+	"github.com/coreos/ignition/config/types"
+	"github.com/coreos/ignition/config/validate"
+	"github.com/coreos/ignition/config/validate/report"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Options controls how ParseYAML and TranspileYAML resolve the ergonomic
+// sugar in a YAML document.
+type Options struct {
+	// FilesDir is the directory that local: file references are resolved
+	// relative to. If empty, local: references are rejected.
+	FilesDir string
+}
+
+// ParseYAML parses a YAML document into a types.Config, reporting any
+// errors, warnings, or deprecations with line and column information taken
+// from the YAML source itself. Unlike TranspileYAML, it never round-trips
+// through an intermediate JSON document: doing so would make any report
+// entry that wasn't already caught by the sugar pass (i.e. most schema
+// violations) carry a line number computed against that intermediate text
+// rather than the YAML the author actually wrote.
+func ParseYAML(raw []byte, options Options) (types.Config, report.Report, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return types.Config{}, reportFromYAMLError(err), err
+	}
+	if len(root.Content) == 0 {
+		return types.Config{}, report.Report{}, config.ErrEmpty
+	}
+
+	var r report.Report
+	doc, err := sugar(root.Content[0], options, &r)
+	if err != nil {
+		return types.Config{}, r, err
+	}
+	if r.IsFatal() {
+		return types.Config{}, r, fmt.Errorf("config is not valid")
+	}
+
+	var cfg types.Config
+	if err := doc.Decode(&cfg); err != nil {
+		line, column, message := decodeErrorPosition(err, doc)
+		r.Add(report.Entry{Kind: report.EntryError, Message: message, Line: line, Column: column})
+		return types.Config{}, r, fmt.Errorf("config is not valid")
+	}
+
+	if err := cfg.Ignition.Version.AssertValid(); err != nil {
+		line, column := doc.Line, doc.Column
+		if versionNode := findChild(doc, "ignition", "version"); versionNode != nil {
+			line, column = versionNode.Line, versionNode.Column
+		}
+		r.Add(report.Entry{Kind: report.EntryError, Message: err.Error(), Line: line, Column: column})
+		return types.Config{}, r, fmt.Errorf("config is not valid")
+	}
+
+	// validate.Validate needs a json.Node AST to attribute findings to byte
+	// offsets; we have no such JSON text here (on purpose), so fall back to
+	// the same source-free validation path config.ParseFromLatest uses when
+	// it cannot build a json.Node AST for its input.
+	r.Merge(validate.ValidateWithoutSource(cfg))
+	if r.IsFatal() {
+		return types.Config{}, r, fmt.Errorf("config is not valid")
+	}
+
+	return cfg, r, nil
+}
+
+// TranspileYAML converts a YAML document with the same schema as
+// types.Config (plus the sugar described in Options) into canonical
+// Ignition JSON, without validating the result against types.Config. Use
+// ParseYAML if you want a parsed and validated types.Config back.
+func TranspileYAML(raw []byte, options Options) ([]byte, report.Report, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return nil, reportFromYAMLError(err), err
+	}
+
+	if len(root.Content) == 0 {
+		return nil, report.Report{}, nil
+	}
+
+	var r report.Report
+	doc, err := sugar(root.Content[0], options, &r)
+	if err != nil {
+		return nil, r, err
+	}
+	if r.IsFatal() {
+		return nil, r, fmt.Errorf("config is not valid")
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, r, err
+	}
+
+	jsonConfig, err := yamlToJSON(out)
+	if err != nil {
+		return nil, r, err
+	}
+
+	return jsonConfig, r, nil
+}
+
+// reportFromYAMLError builds a report.Report whose single entry points at
+// the line in raw that the underlying YAML library reported.
+func reportFromYAMLError(err error) report.Report {
+	var r report.Report
+	message := err.Error()
+	if terr, ok := err.(*yaml.TypeError); ok && len(terr.Errors) > 0 {
+		message = terr.Errors[0]
+	}
+	line := 0
+	if m := yamlTypeErrorLine.FindStringSubmatch(strings.TrimPrefix(message, "yaml: ")); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	r.Add(report.Entry{Kind: report.EntryError, Message: message, Line: line})
+	return r
+}
+
+// yamlTypeErrorLine matches the "line N: " prefix yaml.v3 adds to each
+// message in a *yaml.TypeError, letting us recover the real source line a
+// decode failure occurred on instead of reporting the document's root
+// position for every such error.
+var yamlTypeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// decodeErrorPosition derives the line and column a Decode error should be
+// reported at. yaml.v3 tags each message inside a *yaml.TypeError with the
+// line it came from; when present, that's the specific node that failed to
+// decode, so we prefer it over doc's own position, which is only ever the
+// root of the document being decoded.
+func decodeErrorPosition(err error, doc *yaml.Node) (line, column int, message string) {
+	if terr, ok := err.(*yaml.TypeError); ok && len(terr.Errors) > 0 {
+		if m := yamlTypeErrorLine.FindStringSubmatch(terr.Errors[0]); m != nil {
+			if l, perr := strconv.Atoi(m[1]); perr == nil {
+				return l, 0, m[2]
+			}
+		}
+	}
+	return doc.Line, doc.Column, err.Error()
+}
+
+// findChild walks node along path, a sequence of mapping keys, and returns
+// the value node at the end of it, or nil if path doesn't fully resolve
+// (a missing key, or a non-mapping node partway through).
+func findChild(node *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// sugar walks node, resolving the YAML-only ergonomic fields (local:,
+// compression, string-form sizes) into their plain Ignition equivalents.
+// A local: reference it cannot resolve is recorded on r with the node's own
+// Line/Column rather than an offset into a re-marshaled JSON document; a
+// size string whose unit it does not recognise is left unchanged and
+// nothing is recorded for it.
+func sugar(node *yaml.Node, options Options, r *report.Report) (*yaml.Node, error) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		gzipRequested := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "compression" && node.Content[i+1].Value == "gzip" {
+				gzipRequested = true
+			}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			val := node.Content[i+1]
+			if key.Value == "local" && val.Kind == yaml.ScalarNode {
+				replaced, err := inlineLocal(key, val, options, gzipRequested)
+				if err != nil {
+					r.Add(report.Entry{Kind: report.EntryError, Message: err.Error(), Line: key.Line, Column: key.Column})
+					return node, nil
+				}
+				node.Content[i] = &yaml.Node{Kind: yaml.ScalarNode, Value: "source"}
+				node.Content[i+1] = replaced
+				continue
+			}
+			if key.Value == "size" && val.Kind == yaml.ScalarNode {
+				if bytes, err := parseSize(val.Value); err == nil {
+					val.Value = fmt.Sprintf("%d", bytes)
+					val.Tag = "!!int"
+				}
+			}
+			if _, err := sugar(val, options, r); err != nil {
+				return nil, err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			if _, err := sugar(child, options, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return node, nil
+}
+
+// inlineLocal reads the file named by val (relative to options.FilesDir) and
+// returns a data: URL scalar node suitable for a Storage.Files[].Contents.Source.
+// When gzipRequested is true (a sibling "compression: gzip" key was present), the
+// file's contents are actually gzip-compressed before being base64-encoded,
+// matching the "compression" tag that sibling key sets in the transpiled
+// output; otherwise the raw bytes are base64-encoded as-is.
+func inlineLocal(key, val *yaml.Node, options Options, gzipRequested bool) (*yaml.Node, error) {
+	if options.FilesDir == "" {
+		return nil, fmt.Errorf("local: references require FilesDir to be set")
+	}
+
+	data, err := readLocalFile(options.FilesDir, val.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	if gzipRequested {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(data); err != nil {
+			return nil, fmt.Errorf("gzipping %q: %v", val.Value, err)
+		}
+		if err := gz.Close(); err != nil {
+			return nil, fmt.Errorf("gzipping %q: %v", val.Value, err)
+		}
+		data = buf.Bytes()
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Value: "data:;base64," + encoded,
+	}, nil
+}
+
+// readLocalFile reads name relative to dir, rejecting any path that escapes
+// dir so a config cannot read arbitrary files on the author's machine.
+func readLocalFile(dir, name string) ([]byte, error) {
+	path := filepath.Join(dir, name)
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || len(rel) >= 2 && rel[:2] == ".." {
+		return nil, fmt.Errorf("local: path %q escapes FilesDir", name)
+	}
+
+	return os.ReadFile(path)
+}
+
+// yamlToJSON re-marshals a YAML document already known to be free of
+// YAML-only constructs (anchors, tags, non-string keys) into equivalent
+// JSON for consumption by config.ParseFromLatest.
+func yamlToJSON(raw []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(convertMaps(v))
+}
+
+// convertMaps recursively converts the map[string]interface{} (and
+// map[interface{}]interface{}, which yaml.v3 can still produce for
+// non-string keys) that yaml.Unmarshal produces into the
+// map[string]interface{} that encoding/json requires.
+func convertMaps(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[k] = convertMaps(val)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[fmt.Sprintf("%v", k)] = convertMaps(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = convertMaps(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// parseSize parses human-readable storage sizes like "4GiB" into a byte count.
+func parseSize(s string) (int64, error) {
+	var n int64
+	var unit string
+	if _, err := fmt.Sscanf(s, "%d%s", &n, &unit); err != nil {
+		return 0, err
+	}
+
+	switch unit {
+	case "KiB":
+		return n * 1024, nil
+	case "MiB":
+		return n * 1024 * 1024, nil
+	case "GiB":
+		return n * 1024 * 1024 * 1024, nil
+	case "TiB":
+		return n * 1024 * 1024 * 1024 * 1024, nil
+	default:
+		return 0, fmt.Errorf("unrecognized size unit %q", unit)
+	}
+}
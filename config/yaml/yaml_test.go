@@ -0,0 +1,105 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yaml
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestParseYAMLReportsYAMLLineNumbers checks that a schema violation several
+// lines into a YAML document is reported against the line it actually
+// appears on in the YAML source, not an offset into an intermediate JSON
+// document no one wrote.
+func TestParseYAMLReportsYAMLLineNumbers(t *testing.T) {
+	doc := []byte("ignition:\n  version: not-a-version\n")
+
+	_, r, err := ParseYAML(doc, Options{})
+	if err == nil {
+		t.Fatal("expected an error for an invalid version")
+	}
+	if len(r.Entries) == 0 {
+		t.Fatal("expected at least one report entry")
+	}
+	if r.Entries[0].Line != 2 {
+		t.Errorf("expected the error to be attributed to line 2 (where \"version\" appears), got line %d", r.Entries[0].Line)
+	}
+}
+
+// TestParseYAMLReportsDecodeErrorLine checks that a type mismatch caught by
+// doc.Decode (as opposed to the later AssertValid check) is attributed to
+// the line yaml.v3 itself blames in the *yaml.TypeError, not the root
+// document's position.
+func TestParseYAMLReportsDecodeErrorLine(t *testing.T) {
+	doc := []byte("storage:\n  files: []\n  disks:\n    - device: /dev/sda\n      partitions: 5\n")
+
+	_, r, err := ParseYAML(doc, Options{})
+	if err == nil {
+		t.Fatal("expected an error for a scalar partitions list")
+	}
+	if len(r.Entries) == 0 {
+		t.Fatal("expected at least one report entry")
+	}
+	if r.Entries[0].Line != 5 {
+		t.Errorf("expected the error to be attributed to line 5 (where \"partitions\" appears), got line %d", r.Entries[0].Line)
+	}
+}
+
+// TestInlineLocalGzip checks that a local: reference with a sibling
+// "compression: gzip" key actually gzips the file's contents before
+// base64-encoding them, rather than just claiming the compression tag.
+func TestInlineLocalGzip(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(dir+"/hostname", []byte("host1"), 0644); err != nil {
+		t.Fatalf("writing fixture file: %v", err)
+	}
+
+	doc := []byte("storage:\n  files:\n    - path: /etc/hostname\n      contents:\n        compression: gzip\n        local: hostname\n")
+
+	jsonConfig, r, err := TranspileYAML(doc, Options{FilesDir: dir})
+	if err != nil {
+		t.Fatalf("TranspileYAML: %v (report: %v)", err, r)
+	}
+
+	const marker = `"source":"data:;base64,`
+	idx := strings.Index(string(jsonConfig), marker)
+	if idx < 0 {
+		t.Fatalf("expected a base64 data URL in output, got %s", jsonConfig)
+	}
+
+	rest := string(jsonConfig)[idx+len(marker):]
+	encoded := rest[:strings.IndexByte(rest, '"')]
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decoding base64: %v", err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("source is not valid gzip data: %v", err)
+	}
+	got, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("reading gzip stream: %v", err)
+	}
+	if string(got) != "host1" {
+		t.Fatalf("got %q, want %q", got, "host1")
+	}
+}